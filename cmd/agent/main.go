@@ -8,26 +8,264 @@ import (
 	"fmt"
 	"github.com/hashicorp/yamux"
 	"github.com/sirupsen/logrus"
+	"ligolo-ng/pkg/agent/fronting"
 	"ligolo-ng/pkg/agent/neterror"
 	"ligolo-ng/pkg/agent/smartping"
+	"ligolo-ng/pkg/logging"
+	"ligolo-ng/pkg/metrics"
 	"ligolo-ng/pkg/protocol"
 	"ligolo-ng/pkg/relay"
+	"ligolo-ng/pkg/transport"
+	"math/rand"
 	"net"
 	"os"
 	"os/user"
+	"sync"
 	"syscall"
 	"time"
 )
 
 var listenerConntrack map[int32]net.Conn
 var listenerMap map[int32]net.Listener
+var reverseListenerMap map[int32]net.Listener
 var connTrackID int32
 var listenerID int32
+var yamuxSession *yamux.Session
+
+// listenerSpec records a still-running listener so that, after a reconnect,
+// its accept loop can be reattached to the new session instead of being
+// recreated (which would either fail to rebind its address or silently
+// leak the original listener).
+type listenerSpec struct {
+	id       int32
+	listener Listener
+	reverse  bool
+}
+
+var listenerSpecsMu sync.Mutex
+var listenerSpecs = map[int32]listenerSpec{}
+
+// trackListener records a listener so it can be reattached after a
+// reconnect.
+func trackListener(id int32, listener Listener, reverse bool) {
+	listenerSpecsMu.Lock()
+	defer listenerSpecsMu.Unlock()
+	listenerSpecs[id] = listenerSpec{id: id, listener: listener, reverse: reverse}
+}
+
+// untrackListener forgets a closed listener so it isn't reattached.
+func untrackListener(id int32) {
+	listenerSpecsMu.Lock()
+	defer listenerSpecsMu.Unlock()
+	delete(listenerSpecs, id)
+}
+
+// listenerChansMu guards listenerTrackChan/listenerStopChan.
+var listenerChansMu sync.Mutex
+
+// listenerTrackChan holds each pull-model listener's connTrackChan, fed by
+// its still-running ListenAndServe goroutine for as long as the listener is
+// open, independent of how many times the session has reconnected.
+var listenerTrackChan = map[int32]chan int32{}
+
+// listenerStopChan holds each pull-model listener's error channel, signalled
+// once by ListenAndServe when the listener itself dies.
+var listenerStopChan = map[int32]chan error{}
+
+// replayListeners reattaches every tracked listener after a reconnect.
+// Neither a regular nor a reverse-forward listener is ever recreated here:
+// both keep accepting connections across the drop on their original
+// net.Listener, so re-registering them would at best leak that listener and
+// at worst fail outright with "address already in use".
+//
+//   - A reverse-forward listener pushes every accepted connection on a fresh
+//     yamux stream opened against the package-level yamuxSession var, so it
+//     picks up the new session on its own; nothing needs to be done for it.
+//   - A regular (proxy-pulled) listener only loses its ListenerBindReponse
+//     announcement stream, since that was tied to the now-dead control
+//     connection. reattachPullListener opens a new stream for it and resumes
+//     announcing from the listener's still-live connTrackChan.
+func replayListeners() {
+	listenerSpecsMu.Lock()
+	specs := make([]listenerSpec, 0, len(listenerSpecs))
+	for _, spec := range listenerSpecs {
+		specs = append(specs, spec)
+	}
+	listenerSpecsMu.Unlock()
+
+	for _, spec := range specs {
+		if spec.reverse {
+			logrus.Infof("reconnected: reverse-forward listener %d on %s keeps serving", spec.id, spec.listener.Addr())
+			continue
+		}
+		logrus.Infof("reconnected: reattaching listener %d on %s to the new session", spec.id, spec.listener.Addr())
+		go reattachPullListener(spec.id)
+	}
+}
+
+// reattachPullListener opens a new yamux stream, announces which listener it
+// is resuming, and takes over that listener's ListenerBindReponse loop.
+func reattachPullListener(id int32) {
+	stream, err := yamuxSession.Open()
+	if err != nil {
+		logrus.Errorf("failed to reattach listener %d: %v", id, err)
+		return
+	}
+
+	encoder := protocol.NewEncoder(stream)
+	if err := encoder.Encode(protocol.Envelope{
+		Type:    protocol.MessageListenerReattachRequest,
+		Payload: protocol.ListenerReattachRequestPacket{ListenerID: id},
+	}); err != nil {
+		logrus.Errorf("failed to reattach listener %d: %v", id, err)
+		stream.Close()
+		return
+	}
+
+	listenerChansMu.Lock()
+	connTrackChan, stopChan := listenerTrackChan[id], listenerStopChan[id]
+	listenerChansMu.Unlock()
+	if connTrackChan == nil || stopChan == nil {
+		logrus.Errorf("listener %d has no tracked channels to reattach to", id)
+		stream.Close()
+		return
+	}
+
+	died := serveBindResponses(encoder, connTrackChan, stopChan)
+
+	listenerSpecsMu.Lock()
+	listener := listenerSpecs[id].listener
+	listenerSpecsMu.Unlock()
+	finishPullListener(id, listener, died)
+}
+
+// envelopeEncoder is the subset of *protocol.Encoder that
+// serveBindResponses needs.
+type envelopeEncoder interface {
+	Encode(protocol.Envelope) error
+}
+
+// serveBindResponses announces every ID received on connTrackChan to
+// encoder as a ListenerBindReponse. It returns died == true only when
+// stopChan reported that the listener's own accept loop has died; a
+// transient failure to encode onto the stream (e.g. the control stream died
+// mid-reconnect) just ends the loop without claiming the listener is dead,
+// since the listener and its connTrackChan must survive to be reattached.
+func serveBindResponses(encoder envelopeEncoder, connTrackChan chan int32, stopChan chan error) (died bool) {
+	for {
+		var bindResponse protocol.ListenerBindReponse
+		select {
+		case err := <-stopChan:
+			logrus.Error(err)
+			bindResponse = protocol.ListenerBindReponse{
+				SockID:    0,
+				Err:       true,
+				ErrString: err.Error(),
+			}
+			died = true
+		case connTrackID := <-connTrackChan:
+			bindResponse = protocol.ListenerBindReponse{
+				SockID: connTrackID,
+				Err:    false,
+			}
+		}
+
+		if err := encoder.Encode(protocol.Envelope{
+			Type:    protocol.MessageListenerBindResponse,
+			Payload: bindResponse,
+		}); err != nil {
+			logrus.Error(err)
+			return died
+		}
+
+		if bindResponse.Err {
+			return died
+		}
+	}
+}
+
+// finishPullListener is called once serveBindResponses returns. Only a
+// listener whose own accept loop died (died == true) is closed and
+// forgotten; one that merely lost its bind-response stream is left running
+// so a later reconnect can still reattach to it.
+func finishPullListener(id int32, listener Listener, died bool) {
+	if !died {
+		return
+	}
+
+	listener.Close()
+	untrackListener(id)
+	metrics.ActiveListeners.Dec()
+
+	listenerChansMu.Lock()
+	delete(listenerTrackChan, id)
+	delete(listenerStopChan, id)
+	listenerChansMu.Unlock()
+}
+
+// retrySupervisor reconnects a dial/serve loop with a capped exponential
+// backoff plus jitter, so long-running engagements survive network blips
+// without operator intervention.
+type retrySupervisor struct {
+	maxRetries    int
+	retryInterval time.Duration
+	retryMax      time.Duration
+}
+
+// run calls connect repeatedly (connect only returns on error) until connect
+// has returned maxRetries times. Every return counts, including one that
+// ends a session that had connected successfully: neither the count nor the
+// backoff delay is reset after a successful connection.
+func (r retrySupervisor) run(connect func() error) {
+	delay := r.retryInterval
+	if delay <= 0 {
+		delay = time.Second
+	}
+	retryMax := r.retryMax
+	if retryMax <= 0 {
+		retryMax = 60 * time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := connect()
+		logrus.Errorf("connection lost: %v", err)
+
+		if r.maxRetries > 0 && attempt+1 >= r.maxRetries {
+			logrus.Fatalf("giving up after %d retries", attempt+1)
+		}
+
+		wait := jitter(delay)
+		logrus.Infof("reconnecting in %s (attempt %d)", wait, attempt+1)
+		time.Sleep(wait)
+
+		delay *= 2
+		if delay > retryMax {
+			delay = retryMax
+		}
+	}
+}
+
+// jitter returns d adjusted by a random +/-20% offset, so that many agents
+// reconnecting at once don't all retry in lockstep.
+func jitter(d time.Duration) time.Duration {
+	spread := float64(d) * 0.2
+	offset := (rand.Float64()*2 - 1) * spread
+	return d + time.Duration(offset)
+}
 
 func main() {
 	var ignoreCertificate = flag.Bool("ignore-cert", false, "ignore TLS certificate validation (dangerous), only for debug purposes")
 	var verbose = flag.Bool("v", false, "enable verbose mode")
-	var serverAddr = flag.String("connect", "", "the target domain:port")
+	var serverAddr = flag.String("connect", "", "the target domain:port, a transport URL such as wss://host/path, h2://proxy?target=host:port or quic://host:port, "+
+		"or a fronted dial spec such as sni=fronted.example.com,host=real.c2.tld:443,pin=sha256:...")
+	var logFormat = flag.String("log-format", "text", "log output format, \"text\" or \"json\"")
+	var logFile = flag.String("log-file", "", "also write logs to this file, with rotation")
+	var logMaxSizeMB = flag.Int("log-max-size", 100, "maximum size in megabytes of a log file before it gets rotated")
+	var logMaxAgeDays = flag.Int("log-max-age", 0, "maximum number of days to retain old log files (0 = keep forever)")
+	var logMaxBackups = flag.Int("log-max-backups", 0, "maximum number of old log files to retain (0 = keep all)")
+	var maxRetries = flag.Int("max-retries", 0, "give up reconnecting after this many failed or lost connections in total (0 = retry forever)")
+	var retryInterval = flag.Duration("retry-interval", time.Second, "base delay before the first reconnect attempt, doubled on every subsequent failure")
+	var retryMax = flag.Duration("retry-max", 60*time.Second, "cap on the reconnect backoff delay")
 
 	flag.Parse()
 
@@ -37,40 +275,81 @@ func main() {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	if *serverAddr == "" {
-		logrus.Fatal("please, specify the target host user -connect host:port")
+	var fileSink *logging.FileSinkOptions
+	if *logFile != "" {
+		fileSink = &logging.FileSinkOptions{
+			Path:       *logFile,
+			MaxSizeMB:  *logMaxSizeMB,
+			MaxAgeDays: *logMaxAgeDays,
+			MaxBackups: *logMaxBackups,
+		}
 	}
-	if _, _, err := net.SplitHostPort(*serverAddr); err != nil {
-		logrus.Fatal("invalid connect address, please using host:port")
+	logging.Configure(logrus.StandardLogger(), logging.Format(*logFormat), fileSink)
+
+	if *serverAddr == "" {
+		logrus.Fatal("please, specify the target host using -connect host:port")
 	}
 
+	dialTarget := *serverAddr
 	var tlsConfig tls.Config
 	if *ignoreCertificate {
 		logrus.Warn("warning, certificate validation disabled")
 		tlsConfig.InsecureSkipVerify = true
 	}
 
+	frontCfg, isFronted, err := fronting.Parse(*serverAddr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if isFronted {
+		logrus.Warnf("domain fronting enabled: sending SNI %q while dialing %s, PKI chain validation disabled", frontCfg.SNI, frontCfg.Host)
+		frontTLSConfig, err := frontCfg.TLSConfig()
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		tlsConfig = *frontTLSConfig
+		dialTarget = frontCfg.Host
+	}
+
 	listenerConntrack = make(map[int32]net.Conn)
 	listenerMap = make(map[int32]net.Listener)
-	dialer, err := tls.Dial("tcp", *serverAddr, &tlsConfig)
+	reverseListenerMap = make(map[int32]net.Listener)
+
+	retry := retrySupervisor{
+		maxRetries:    *maxRetries,
+		retryInterval: *retryInterval,
+		retryMax:      *retryMax,
+	}
+	retry.run(func() error { return connectAndServe(dialTarget, &tlsConfig) })
+}
+
+// connectAndServe dials the proxy, wraps the connection in a yamux session
+// and serves incoming streams until the session errors out. It never exits
+// the process itself; that decision belongs to the retrySupervisor in main.
+func connectAndServe(dialTarget string, tlsConfig *tls.Config) error {
+	dialer, err := transport.Dial(context.Background(), dialTarget, tlsConfig)
 	if err != nil {
-		logrus.Fatal(err)
+		return err
 	}
 
 	yamuxConn, err := yamux.Server(dialer, yamux.DefaultConfig())
 	if err != nil {
-		logrus.Fatal(err)
+		return err
+	}
+
+	firstConnect := yamuxSession == nil
+	yamuxSession = yamuxConn
+	if !firstConnect {
+		replayListeners()
 	}
 
 	for {
 		conn, err := yamuxConn.Accept()
 		if err != nil {
-			logrus.Error(err)
-			return
+			return err
 		}
 		go handleConn(conn)
 	}
-
 }
 
 // Listener is the base class implementing listener sockets for Ligolo
@@ -158,7 +437,9 @@ func handleConn(conn net.Conn) {
 			logrus.Fatal(err)
 		}
 		if connectPacket.Established {
-			relay.StartRelay(targetConn, conn)
+			metrics.TrackRelay(network, "connect", func() {
+				relay.StartRelay(metrics.CountingConn{Conn: targetConn}, conn)
+			})
 		}
 	case protocol.MessageHostPingRequest:
 		pingRequest := e.(protocol.HostPingRequestPacket)
@@ -210,7 +491,15 @@ func handleConn(conn net.Conn) {
 
 		var err error
 		if lis, ok := listenerMap[closeRequest.ListenerID]; ok {
+			// Closing lis ends its ListenAndServe loop, whose bind-response
+			// loop then calls finishPullListener, which decrements
+			// ActiveListeners for it.
 			err = lis.Close()
+			untrackListener(closeRequest.ListenerID)
+			listenerChansMu.Lock()
+			delete(listenerTrackChan, closeRequest.ListenerID)
+			delete(listenerStopChan, closeRequest.ListenerID)
+			listenerChansMu.Unlock()
 		} else {
 			err = errors.New("invalid listener id")
 		}
@@ -251,13 +540,20 @@ func handleConn(conn net.Conn) {
 			return
 		}
 
+		id := listenerID
 		listenerResponse := protocol.ListenerResponsePacket{
-			ListenerID: listenerID,
+			ListenerID: id,
 			Err:        false,
 			ErrString:  "",
 		}
-		listenerMap[listenerID] = listener.Listener
+		listenerMap[id] = listener.Listener
+		trackListener(id, listener, false)
+		listenerChansMu.Lock()
+		listenerTrackChan[id] = connTrackChan
+		listenerStopChan[id] = stopChan
+		listenerChansMu.Unlock()
 		listenerID++
+		metrics.ActiveListeners.Inc()
 
 		if err := encoder.Encode(protocol.Envelope{
 			Type:    protocol.MessageListenerResponse,
@@ -271,37 +567,9 @@ func handleConn(conn net.Conn) {
 				stopChan <- err
 			}
 		}()
-		defer listener.Close()
-
-		for {
-			var bindResponse protocol.ListenerBindReponse
-			select {
-			case err := <-stopChan:
-				logrus.Error(err)
-				bindResponse = protocol.ListenerBindReponse{
-					SockID:    0,
-					Err:       true,
-					ErrString: err.Error(),
-				}
-			case connTrackID := <-connTrackChan:
-				bindResponse = protocol.ListenerBindReponse{
-					SockID: connTrackID,
-					Err:    false,
-				}
-			}
 
-			if err := encoder.Encode(protocol.Envelope{
-				Type:    protocol.MessageListenerBindResponse,
-				Payload: bindResponse,
-			}); err != nil {
-				logrus.Error(err)
-			}
-
-			if bindResponse.Err {
-				break
-			}
-
-		}
+		died := serveBindResponses(encoder, connTrackChan, stopChan)
+		finishPullListener(id, listener, died)
 	case protocol.MessageListenerSockRequest:
 		sockRequest := e.(protocol.ListenerSockRequestPacket)
 		encoder := protocol.NewEncoder(conn)
@@ -325,10 +593,92 @@ func handleConn(conn net.Conn) {
 		}
 
 		netConn := listenerConntrack[sockRequest.SockID]
-		relay.StartRelay(netConn, conn)
+		metrics.TrackRelay(netConn.LocalAddr().Network(), "listener", func() {
+			relay.StartRelay(metrics.CountingConn{Conn: netConn}, conn)
+		})
+
+	case protocol.MessageReverseListenerRequest:
+		reverseRequest := e.(protocol.ReverseListenerRequestPacket)
+		encoder := protocol.NewEncoder(conn)
+
+		listener, err := NewListener(reverseRequest.Network, reverseRequest.Address)
+		if err != nil {
+			reverseResponse := protocol.ReverseListenerResponsePacket{
+				ListenerID: 0,
+				Err:        true,
+				ErrString:  err.Error(),
+			}
+			if err := encoder.Encode(protocol.Envelope{
+				Type:    protocol.MessageReverseListenerResponse,
+				Payload: reverseResponse,
+			}); err != nil {
+				logrus.Error(err)
+			}
+			return
+		}
+
+		reverseResponse := protocol.ReverseListenerResponsePacket{
+			ListenerID: listenerID,
+		}
+		reverseListenerMap[listenerID] = listener.Listener
+		trackListener(listenerID, listener, true)
+		metrics.ActiveListeners.Inc()
+
+		if err := encoder.Encode(protocol.Envelope{
+			Type:    protocol.MessageReverseListenerResponse,
+			Payload: reverseResponse,
+		}); err != nil {
+			logrus.Error(err)
+		}
+
+		go serveReverseListener(listener, listenerID)
+		listenerID++
 
 	case protocol.MessageClose:
 		os.Exit(0)
 
 	}
 }
+
+// serveReverseListener accepts connections on a reverse-forward listener and
+// pushes each one back to the proxy on its own yamux stream, where it will
+// be injected into the active agent's NetStack connection pool.
+func serveReverseListener(listener Listener, listenerID int32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logrus.Error(err)
+			return
+		}
+		go pushReverseConn(conn, listenerID)
+	}
+}
+
+// pushReverseConn opens a new yamux stream to the proxy, announces the
+// originating remote address, and relays the connection through it.
+func pushReverseConn(conn net.Conn, listenerID int32) {
+	stream, err := yamuxSession.Open()
+	if err != nil {
+		logrus.Error(err)
+		conn.Close()
+		return
+	}
+
+	encoder := protocol.NewEncoder(stream)
+	if err := encoder.Encode(protocol.Envelope{
+		Type: protocol.MessageReverseConnRequest,
+		Payload: protocol.ReverseConnRequestPacket{
+			ListenerID: listenerID,
+			RemoteAddr: conn.RemoteAddr().String(),
+		},
+	}); err != nil {
+		logrus.Error(err)
+		conn.Close()
+		stream.Close()
+		return
+	}
+
+	metrics.TrackRelay(conn.LocalAddr().Network(), "reverse-listener", func() {
+		relay.StartRelay(metrics.CountingConn{Conn: conn}, stream)
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"net"
+	"sync/atomic"
+
+	"github.com/hashicorp/yamux"
+	"github.com/sirupsen/logrus"
+	"ligolo-ng/pkg/logging"
+	"ligolo-ng/pkg/metrics"
+	"ligolo-ng/pkg/proxy/netstack"
+	"ligolo-ng/pkg/proxy/socks"
+)
+
+// nextSessionID hands out the SessionManager IDs registered through
+// NetStack.AddSession as agents connect; there is no console yet to let an
+// operator pick or reuse them.
+var nextSessionID int32
+
+func main() {
+	var listenAddr = flag.String("listen", "0.0.0.0:11601", "address to accept agent connections on")
+	var tlsCert = flag.String("tls-cert", "", "TLS certificate used to accept agent connections")
+	var tlsKey = flag.String("tls-key", "", "TLS private key matching -tls-cert")
+	var tunName = flag.String("tun-name", "ligolo", "name of the TUN interface agents are routed through")
+	var socksListen = flag.String("socks-listen", "", "also serve a local SOCKS5/HTTP CONNECT proxy on this address through the active agent, e.g. 127.0.0.1:1080")
+	var metricsListen = flag.String("metrics-listen", "", "also serve Prometheus metrics on this address, e.g. 127.0.0.1:9090")
+	var reverseTarget = flag.String("reverse-target", "", "host:port that agent-side reverse-forward listeners relay their accepted connections to")
+	var verbose = flag.Bool("v", false, "enable verbose mode")
+	var logFormat = flag.String("log-format", "text", "log output format, \"text\" or \"json\"")
+	var logFile = flag.String("log-file", "", "also write logs to this file, with rotation")
+	var logMaxSizeMB = flag.Int("log-max-size", 100, "maximum size in megabytes of a log file before it gets rotated")
+	var logMaxAgeDays = flag.Int("log-max-age", 0, "maximum number of days to retain old log files (0 = keep forever)")
+	var logMaxBackups = flag.Int("log-max-backups", 0, "maximum number of old log files to retain (0 = keep all)")
+
+	flag.Parse()
+
+	logrus.SetReportCaller(*verbose)
+	if *verbose {
+		logrus.SetLevel(logrus.DebugLevel)
+	}
+
+	var fileSink *logging.FileSinkOptions
+	if *logFile != "" {
+		fileSink = &logging.FileSinkOptions{
+			Path:       *logFile,
+			MaxSizeMB:  *logMaxSizeMB,
+			MaxAgeDays: *logMaxAgeDays,
+			MaxBackups: *logMaxBackups,
+		}
+	}
+	logging.Configure(logrus.StandardLogger(), logging.Format(*logFormat), fileSink)
+
+	if *tlsCert == "" || *tlsKey == "" {
+		logrus.Fatal("please specify -tls-cert and -tls-key to accept agent connections")
+	}
+	cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+	if err != nil {
+		logrus.Fatalf("failed to load TLS certificate: %v", err)
+	}
+
+	ns := netstack.NewStack(*tunName, nil)
+
+	if *socksListen != "" {
+		go func() {
+			if err := socks.NewServer(ns).ListenAndServe("tcp", *socksListen); err != nil {
+				logrus.Errorf("socks: %v", err)
+			}
+		}()
+	}
+	if *metricsListen != "" {
+		go func() {
+			if err := metrics.Serve(*metricsListen); err != nil {
+				logrus.Errorf("metrics: %v", err)
+			}
+		}()
+	}
+
+	lis, err := tls.Listen("tcp", *listenAddr, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		logrus.Fatalf("failed to listen on %s: %v", *listenAddr, err)
+	}
+	logrus.Infof("listening for agents on %s", *listenAddr)
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			logrus.Errorf("accept: %v", err)
+			continue
+		}
+		go serveAgent(conn, ns, *reverseTarget)
+	}
+}
+
+// serveAgent wraps a newly accepted agent connection in a yamux session,
+// registers it with ns as a new routable session, and serves it until the
+// session goes away.
+func serveAgent(conn net.Conn, ns *netstack.NetStack, reverseTarget string) {
+	session, err := yamux.Client(conn, yamux.DefaultConfig())
+	if err != nil {
+		logrus.Errorf("agent %s: failed to establish yamux session: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	id := int(atomic.AddInt32(&nextSessionID, 1))
+	pool := netstack.NewConnPool(session)
+	pool.ReverseTarget = reverseTarget
+
+	ns.AddSession(id, pool)
+	if err := ns.SetActiveSession(id); err != nil {
+		logrus.Errorf("agent %s: failed to activate session %d: %v", conn.RemoteAddr(), id, err)
+	}
+	logrus.Infof("agent %s connected as session %d (active sessions: %v)", conn.RemoteAddr(), id, ns.Sessions())
+
+	go func() {
+		if err := netstack.ServeReverseConns(session, ns); err != nil {
+			logrus.Debugf("agent %s: reverse-conn stream closed: %v", conn.RemoteAddr(), err)
+		}
+	}()
+
+	<-session.CloseChan()
+	ns.RemoveSession(id)
+	logrus.Infof("agent %s (session %d) disconnected", conn.RemoteAddr(), id)
+}
@@ -0,0 +1,53 @@
+// Package logging configures logrus output format and an optional rotating
+// file sink, shared by the agent and proxy binaries.
+package logging
+
+import (
+	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"io"
+)
+
+// Format selects how log records are rendered.
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+// FileSinkOptions configures rotation for an on-disk log sink, mirroring the
+// size/age/backup-count knobs of the lumberjack-based sinks used by most
+// logrus deployments.
+type FileSinkOptions struct {
+	// Path is the log file to write to.
+	Path string
+	// MaxSizeMB is the size, in megabytes, a log file can reach before it is rotated.
+	MaxSizeMB int
+	// MaxAgeDays is the maximum number of days to retain old log files.
+	MaxAgeDays int
+	// MaxBackups is the maximum number of old log files to retain.
+	MaxBackups int
+}
+
+// Configure sets logger's formatter according to format and, when sink is
+// non-nil, tees output to a rotating file alongside the existing writer.
+func Configure(logger *logrus.Logger, format Format, sink *FileSinkOptions) {
+	if format == FormatJSON {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logger.SetFormatter(&logrus.TextFormatter{})
+	}
+
+	if sink == nil {
+		return
+	}
+
+	rotator := &lumberjack.Logger{
+		Filename:   sink.Path,
+		MaxSize:    sink.MaxSizeMB,
+		MaxAge:     sink.MaxAgeDays,
+		MaxBackups: sink.MaxBackups,
+	}
+	logger.SetOutput(io.MultiWriter(logger.Out, rotator))
+}
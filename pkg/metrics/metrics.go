@@ -0,0 +1,90 @@
+// Package metrics exposes Prometheus counters/histograms describing relayed
+// connections, for Ligolo-ng deployments that want visibility beyond the
+// logs. Serve is meant to be called from the proxy's main only: the proxy is
+// the operator-facing side of the tunnel, and exposing an unauthenticated
+// /metrics listener from the agent binary would plant an extra,
+// fingerprintable port on the target host. Both the agent and proxy relay
+// paths may still call the counters/TrackRelay directly to instrument their
+// own process.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+)
+
+var (
+	// ConnTotal counts relayed connections by protocol ("tcp"/"udp") and the
+	// relay path that created them ("connect", "listener" or
+	// "reverse-listener"). path is a fixed, small set of call sites, not a
+	// per-connection value (a remote address would blow up label
+	// cardinality and isn't what this metric is for).
+	ConnTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ligolo_conn_total",
+		Help: "Total number of relayed connections, by protocol and relay path.",
+	}, []string{"proto", "path"})
+
+	// BytesRelayed counts bytes copied in either direction across all relays.
+	BytesRelayed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ligolo_bytes_relayed",
+		Help: "Total bytes relayed between agents and their targets.",
+	})
+
+	// ConnDuration tracks how long relayed connections stay open.
+	ConnDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ligolo_conn_duration_seconds",
+		Help:    "Duration of relayed connections, from dial to close.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// ActiveListeners tracks the number of listeners currently bound by this
+	// process. Only the agent binary updates it; the proxy does not collect
+	// the agents' values, so its own /metrics reports the proxy's count only.
+	ActiveListeners = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "ligolo_active_listeners",
+		Help: "Number of listeners currently active in this process.",
+	})
+)
+
+// Serve exposes the registered collectors on addr at /metrics, blocking
+// until the HTTP server stops.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// TrackRelay increments ConnTotal for proto/path and times the call to
+// relay, recording the elapsed time in ConnDuration once it returns. path
+// identifies the call site (e.g. "connect", "listener", "reverse-listener"),
+// not the remote peer.
+func TrackRelay(proto, path string, relay func()) {
+	ConnTotal.WithLabelValues(proto, path).Inc()
+	timer := prometheus.NewTimer(ConnDuration)
+	defer timer.ObserveDuration()
+	relay()
+}
+
+// CountingConn wraps a net.Conn, adding every byte read or written to
+// BytesRelayed. Wrap a connection with it before handing it to
+// relay.StartRelay to have its traffic counted.
+type CountingConn struct {
+	net.Conn
+}
+
+// Read implements net.Conn.
+func (c CountingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	BytesRelayed.Add(float64(n))
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c CountingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	BytesRelayed.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,17 @@
+package protocol
+
+// MessageListenerReattachRequest is sent by the agent, on a freshly opened
+// yamux stream, right after reconnecting to the proxy. It resumes the
+// ListenerBindReponse announcement stream for a pull-model listener created
+// with MessageListenerRequest whose original control stream died along with
+// the previous session - the listener itself, and the connections it has
+// already accepted, are untouched by the reconnect.
+const (
+	MessageListenerReattachRequest = 103
+)
+
+// ListenerReattachRequestPacket names the listener whose bind-response
+// stream is being resumed.
+type ListenerReattachRequestPacket struct {
+	ListenerID int32
+}
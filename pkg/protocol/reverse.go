@@ -0,0 +1,35 @@
+package protocol
+
+// Reverse-forward message types. Unlike a regular agent-side listener (pulled
+// by the proxy through MessageListenerSockRequest), a reverse-forward
+// listener pushes every accepted connection back to the proxy on its own
+// yamux stream, where it is injected into the active agent's NetStack
+// connection pool as a synthetic connection.
+const (
+	MessageReverseListenerRequest = iota + 100
+	MessageReverseListenerResponse
+	MessageReverseConnRequest
+)
+
+// ReverseListenerRequestPacket asks the agent to open a reverse-forward
+// listener on Network/Address.
+type ReverseListenerRequestPacket struct {
+	Network string
+	Address string
+}
+
+// ReverseListenerResponsePacket acknowledges a ReverseListenerRequestPacket.
+type ReverseListenerResponsePacket struct {
+	ListenerID int32
+	Err        bool
+	ErrString  string
+}
+
+// ReverseConnRequestPacket is sent by the agent, on a freshly opened yamux
+// stream, every time a reverse-forward listener accepts a new connection.
+// The proxy injects the remainder of the stream into the NetStack connection
+// pool as if RemoteAddr had dialed in from outside.
+type ReverseConnRequestPacket struct {
+	ListenerID int32
+	RemoteAddr string
+}
@@ -0,0 +1,169 @@
+package netstack
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/hashicorp/yamux"
+	"github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/waiter"
+	"ligolo-ng/pkg/protocol"
+	"ligolo-ng/pkg/relay"
+)
+
+// ConnPool dispatches the connections a NetStack's forwarders hand it to one
+// connected agent. A TCPConn/UDPConn (the operator's own OS dialing into the
+// tunneled network through an `ip route`'d destination) opens a fresh yamux
+// stream to the agent and asks it to dial the real destination, the same
+// MessageConnectRequest/MessageConnectResponse exchange cmd/agent/main.go's
+// handleConn already implements. A RawConn (an already-established
+// connection pushed by an agent-side reverse-forward listener, see
+// ServeReverseConns) has no destination for the agent to dial - ReverseTarget
+// says where the proxy should relay it to instead.
+type ConnPool struct {
+	session *yamux.Session
+
+	// ReverseTarget is the host:port the proxy relays RawConn connections
+	// to. It is a single pool-wide destination rather than one the operator
+	// picks per reverse listener, since there is no console in this tree
+	// yet to carry a per-listener target down to InjectReverseConn. Empty
+	// means reverse connections are logged and dropped.
+	ReverseTarget string
+}
+
+// NewConnPool creates a ConnPool that dials out through session, the yamux
+// session established with one connected agent.
+func NewConnPool(session *yamux.Session) *ConnPool {
+	return &ConnPool{session: session}
+}
+
+// Closed reports whether the pool's underlying agent session has gone away.
+func (p *ConnPool) Closed() bool {
+	return p.session.IsClosed()
+}
+
+// Add dispatches conn to the agent (TCPConn/UDPConn) or to ReverseTarget
+// (RawConn).
+func (p *ConnPool) Add(conn TunConn) error {
+	if p.Closed() {
+		return errors.New("connection pool is closed")
+	}
+
+	switch {
+	case conn.IsTCP():
+		go p.handleTCP(conn.GetTCP())
+	case conn.IsUDP():
+		go p.handleUDP(conn.GetUDP())
+	case conn.IsRaw():
+		go p.handleRaw(conn.GetRaw())
+	default:
+		return fmt.Errorf("unsupported connection type %d", conn.Protocol)
+	}
+	return nil
+}
+
+// handleTCP completes the forwarder's endpoint, asks the agent to dial the
+// connection's original destination, and relays bytes between the two once
+// the agent confirms the dial succeeded.
+func (p *ConnPool) handleTCP(conn TCPConn) {
+	var wq waiter.Queue
+	ep, err := conn.Request.CreateEndpoint(&wq)
+	if err != nil {
+		conn.Request.Complete(true)
+		logrus.Errorf("netstack: failed to create TCP endpoint: %v", err)
+		return
+	}
+	conn.Request.Complete(false)
+
+	local := gonet.NewTCPConn(&wq, ep)
+	p.relayThroughAgent(local, "tcp", conn.EndpointID.LocalAddress.String(), conn.EndpointID.LocalPort)
+}
+
+// handleUDP mirrors handleTCP for a UDP forwarder request.
+func (p *ConnPool) handleUDP(conn UDPConn) {
+	var wq waiter.Queue
+	ep, err := conn.Request.CreateEndpoint(&wq)
+	if err != nil {
+		logrus.Errorf("netstack: failed to create UDP endpoint: %v", err)
+		return
+	}
+
+	local := gonet.NewUDPConn(&wq, ep)
+	p.relayThroughAgent(local, "udp", conn.EndpointID.LocalAddress.String(), conn.EndpointID.LocalPort)
+}
+
+// relayThroughAgent opens a yamux stream to the agent, asks it to dial
+// network://addr:port, and relays local against the stream once the agent
+// confirms the connection was established.
+func (p *ConnPool) relayThroughAgent(local net.Conn, network, addr string, port uint16) {
+	stream, err := p.session.Open()
+	if err != nil {
+		logrus.Errorf("netstack: failed to open stream to agent: %v", err)
+		local.Close()
+		return
+	}
+
+	transport := protocol.TransportTCP
+	if network == "udp" {
+		transport = protocol.TransportUDP
+	}
+	netVersion := protocol.Networkv4
+	if strings.Contains(addr, ":") {
+		netVersion = protocol.Networkv6
+	}
+
+	encoder := protocol.NewEncoder(stream)
+	if err := encoder.Encode(protocol.Envelope{
+		Type: protocol.MessageConnectRequest,
+		Payload: protocol.ConnectRequestPacket{
+			Address:   addr,
+			Port:      port,
+			Transport: transport,
+			Net:       netVersion,
+		},
+	}); err != nil {
+		logrus.Errorf("netstack: failed to send connect request: %v", err)
+		local.Close()
+		stream.Close()
+		return
+	}
+
+	decoder := protocol.NewDecoder(stream)
+	if err := decoder.Decode(); err != nil {
+		logrus.Errorf("netstack: failed to read connect response: %v", err)
+		local.Close()
+		stream.Close()
+		return
+	}
+
+	resp := decoder.Envelope.Payload.(protocol.ConnectResponsePacket)
+	if !resp.Established {
+		local.Close()
+		stream.Close()
+		return
+	}
+
+	relay.StartRelay(local, stream)
+}
+
+// handleRaw relays an already-established connection pushed by an agent-side
+// reverse-forward listener to ReverseTarget.
+func (p *ConnPool) handleRaw(conn RawConn) {
+	if p.ReverseTarget == "" {
+		logrus.Warnf("netstack: dropping reverse connection from %s: no -reverse-target configured", conn.RemoteAddr)
+		conn.Conn.Close()
+		return
+	}
+
+	target, err := net.Dial("tcp", p.ReverseTarget)
+	if err != nil {
+		logrus.Errorf("netstack: failed to dial reverse target %s: %v", p.ReverseTarget, err)
+		conn.Conn.Close()
+		return
+	}
+
+	relay.StartRelay(target, conn.Conn)
+}
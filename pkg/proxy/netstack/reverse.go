@@ -0,0 +1,74 @@
+package netstack
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/yamux"
+	"github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"ligolo-ng/pkg/protocol"
+)
+
+// ServeReverseConns accepts the yamux streams an agent opens on its own
+// (one per connection its reverse-forward listeners accept), decodes the
+// leading ReverseConnRequestPacket envelope and injects the remainder of
+// each stream into ns via InjectReverseConn. It should be started once per
+// connected agent session, alongside whatever serves that agent's main
+// control stream, and returns once session is closed.
+func ServeReverseConns(session *yamux.Session, ns *NetStack) error {
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return err
+		}
+		go handleReverseConnStream(stream, ns)
+	}
+}
+
+// handleReverseConnStream decodes a single agent-opened stream and injects
+// it into ns, closing the stream on any error.
+func handleReverseConnStream(stream net.Conn, ns *NetStack) {
+	decoder := protocol.NewDecoder(stream)
+	if err := decoder.Decode(); err != nil {
+		logrus.Errorf("reverse: failed to decode agent-opened stream: %v", err)
+		stream.Close()
+		return
+	}
+
+	if decoder.Envelope.Type != protocol.MessageReverseConnRequest {
+		logrus.Errorf("reverse: unexpected message type %d on agent-opened stream", decoder.Envelope.Type)
+		stream.Close()
+		return
+	}
+
+	req := decoder.Envelope.Payload.(protocol.ReverseConnRequestPacket)
+
+	host, portStr, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		logrus.Errorf("reverse: invalid remote address %q for listener %d: %v", req.RemoteAddr, req.ListenerID, err)
+		stream.Close()
+		return
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		logrus.Errorf("reverse: invalid remote port %q for listener %d: %v", portStr, req.ListenerID, err)
+		stream.Close()
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		logrus.Errorf("reverse: invalid remote IP %q for listener %d", host, req.ListenerID)
+		stream.Close()
+		return
+	}
+
+	if err := ns.InjectReverseConn(stream, tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFromSlice(ip),
+		Port: uint16(port),
+	}); err != nil {
+		logrus.Errorf("reverse: failed to inject connection for listener %d: %v", req.ListenerID, err)
+		stream.Close()
+	}
+}
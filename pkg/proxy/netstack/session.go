@@ -0,0 +1,143 @@
+package netstack
+
+import (
+	"fmt"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"net/netip"
+	"sync"
+)
+
+// route associates a destination CIDR with the session that should handle
+// traffic for it.
+type route struct {
+	cidr      netip.Prefix
+	sessionID int
+}
+
+// SessionManager tracks the connection pools of every concurrently
+// connected agent ("session") and the routing table used to pick which
+// session a given destination address should be dispatched to. A single
+// NetStack/TUN pair can therefore pivot through several compromised hosts
+// at once instead of only the last one selected with SetConnPool.
+//
+// A NetStack owns one SessionManager (created in NewStack) and exposes it
+// through its own AddSession/RemoveSession/SetActiveSession/AddRoute/
+// Sessions methods; console commands ("session list/select", "route add
+// 10.0.0.0/8 via 3") should call those rather than constructing a
+// SessionManager directly.
+type SessionManager struct {
+	mu     sync.Mutex
+	pools  map[int]*ConnPool
+	active int
+	routes []route
+}
+
+// NewSessionManager creates an empty SessionManager.
+func NewSessionManager() *SessionManager {
+	return &SessionManager{pools: make(map[int]*ConnPool)}
+}
+
+// AddSession registers connPool under sessionID. If no session is active
+// yet, sessionID becomes the active one.
+func (m *SessionManager) AddSession(sessionID int, connPool *ConnPool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, hadActive := m.pools[m.active]
+	m.pools[sessionID] = connPool
+	if !hadActive {
+		m.active = sessionID
+	}
+}
+
+// RemoveSession drops sessionID, along with any routes pointing to it. If
+// sessionID was the active session, no session is active until SetActive is
+// called again or AddSession registers a new session, which then becomes
+// the active one.
+func (m *SessionManager) RemoveSession(sessionID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pools, sessionID)
+
+	filtered := m.routes[:0]
+	for _, r := range m.routes {
+		if r.sessionID != sessionID {
+			filtered = append(filtered, r)
+		}
+	}
+	m.routes = filtered
+}
+
+// SetActive selects sessionID as the fallback session used when no route
+// matches a destination address.
+func (m *SessionManager) SetActive(sessionID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pools[sessionID]; !ok {
+		return fmt.Errorf("unknown session %d", sessionID)
+	}
+	m.active = sessionID
+	return nil
+}
+
+// List returns the currently registered session IDs, for the console's
+// "session list" command.
+func (m *SessionManager) List() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]int, 0, len(m.pools))
+	for id := range m.pools {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// AddRoute directs traffic destined for cidr to sessionID.
+func (m *SessionManager) AddRoute(cidr string, sessionID int) error {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return fmt.Errorf("invalid route CIDR %q: %w", cidr, err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pools[sessionID]; !ok {
+		return fmt.Errorf("unknown session %d", sessionID)
+	}
+	m.routes = append(m.routes, route{cidr: prefix, sessionID: sessionID})
+	return nil
+}
+
+// PoolFor resolves the ConnPool that should handle addr: the most
+// specific matching route, or the active session if none match.
+func (m *SessionManager) PoolFor(addr tcpip.Address) (*ConnPool, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ip, ok := netip.AddrFromSlice(addr.AsSlice())
+	if !ok {
+		return nil, false
+	}
+
+	best := route{sessionID: -1}
+	for _, r := range m.routes {
+		if !r.cidr.Contains(ip) {
+			continue
+		}
+		if best.sessionID == -1 || r.cidr.Bits() > best.cidr.Bits() {
+			best = r
+		}
+	}
+
+	sessionID := m.active
+	if best.sessionID != -1 {
+		sessionID = best.sessionID
+	}
+
+	pool, ok := m.pools[sessionID]
+	return pool, ok
+}
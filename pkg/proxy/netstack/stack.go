@@ -1,22 +1,26 @@
 package netstack
 
 import (
+	"errors"
 	"github.com/sirupsen/logrus"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
-	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
-	"gvisor.dev/gvisor/pkg/tcpip/link/rawfile"
-	"gvisor.dev/gvisor/pkg/tcpip/link/tun"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/icmp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
-	"log"
+	"ligolo-ng/pkg/tun"
+	"net"
 	"sync"
 )
 
+// rawProtocolNumber flags a TunConn carrying an already-established
+// connection (e.g. pushed by an agent-side reverse-forward listener) rather
+// than a gVisor forwarder request.
+const rawProtocolNumber tcpip.TransportProtocolNumber = 0xff00
+
 type TunConn struct {
 	Protocol tcpip.TransportProtocolNumber
 	Handler  interface{}
@@ -52,6 +56,16 @@ func (t TunConn) GetICMP() ICMPConn {
 	return t.Handler.(ICMPConn)
 }
 
+// IsRaw check if the current TunConn wraps an already-established connection
+func (t TunConn) IsRaw() bool {
+	return t.Protocol == rawProtocolNumber
+}
+
+// GetRaw returns the handler as a RawConn
+func (t TunConn) GetRaw() RawConn {
+	return t.Handler.(RawConn)
+}
+
 // Terminate is call when connections need to be terminated. For now, this is only useful for TCP connections
 func (t TunConn) Terminate(reset bool) {
 	if t.IsTCP() {
@@ -76,16 +90,25 @@ type ICMPConn struct {
 	Request *stack.PacketBuffer
 }
 
+// RawConn wraps a pre-established net.Conn, such as one pushed by an
+// agent-side reverse-forward listener, that should be handled as an
+// already-connected socket rather than dialed through the gVisor stack.
+type RawConn struct {
+	Conn       net.Conn
+	RemoteAddr tcpip.FullAddress
+}
+
 // NetStack is the structure used to store the connection pool and the gvisor network stack
 type NetStack struct {
-	pool  *ConnPool
-	stack *stack.Stack
+	pool     *ConnPool
+	sessions *SessionManager
+	stack    *stack.Stack
 	sync.Mutex
 }
 
 // NewStack registers a new GVisor Network Stack
 func NewStack(tunName string, connPool *ConnPool) *NetStack {
-	ns := NetStack{pool: connPool}
+	ns := NetStack{pool: connPool, sessions: NewSessionManager()}
 	ns.new(tunName)
 	return &ns
 }
@@ -102,21 +125,117 @@ func (s *NetStack) SetConnPool(connPool *ConnPool) {
 	s.Unlock()
 }
 
-// New creates a new userland network stack (using Gvisor) that listen on a tun interface.
-func (s *NetStack) new(tunName string) *stack.Stack {
-	mtu, err := rawfile.GetMTU(tunName)
-	if err != nil {
-		logrus.Fatal(err)
+// SetSessionManager attaches a SessionManager so that the TCP/UDP forwarders
+// can route a connection to the session owning its destination CIDR instead
+// of always dispatching to the single pool set via SetConnPool.
+func (s *NetStack) SetSessionManager(sessions *SessionManager) {
+	s.Lock()
+	s.sessions = sessions
+	s.Unlock()
+}
+
+// AddSession registers connPool as sessionID's pool in the NetStack's
+// SessionManager, so that AddRoute can direct traffic to it. This is the
+// console's entry point for "session" bookkeeping when an agent connects -
+// see SessionManager for the matching RemoveSession/SetActiveSession/
+// AddRoute/Sessions calls.
+func (s *NetStack) AddSession(sessionID int, connPool *ConnPool) {
+	s.Lock()
+	sessions := s.sessions
+	s.Unlock()
+	sessions.AddSession(sessionID, connPool)
+}
+
+// RemoveSession drops sessionID from the NetStack's SessionManager, for the
+// console to call when an agent disconnects.
+func (s *NetStack) RemoveSession(sessionID int) {
+	s.Lock()
+	sessions := s.sessions
+	s.Unlock()
+	sessions.RemoveSession(sessionID)
+}
+
+// SetActiveSession selects sessionID as the fallback used when no route
+// matches a destination address, for the console's "session select".
+func (s *NetStack) SetActiveSession(sessionID int) error {
+	s.Lock()
+	sessions := s.sessions
+	s.Unlock()
+	return sessions.SetActive(sessionID)
+}
+
+// AddRoute directs traffic destined for cidr to sessionID, for the
+// console's "route add" command.
+func (s *NetStack) AddRoute(cidr string, sessionID int) error {
+	s.Lock()
+	sessions := s.sessions
+	s.Unlock()
+	return sessions.AddRoute(cidr, sessionID)
+}
+
+// Sessions returns the currently registered session IDs, for the console's
+// "session list" command.
+func (s *NetStack) Sessions() []int {
+	s.Lock()
+	sessions := s.sessions
+	s.Unlock()
+	return sessions.List()
+}
+
+// poolFor resolves the ConnPool that should receive a connection bound for
+// dst: the SessionManager's routing table when one is attached, otherwise
+// the single pool set through SetConnPool.
+func (s *NetStack) poolFor(dst tcpip.Address) *ConnPool {
+	s.Lock()
+	sessions := s.sessions
+	pool := s.pool
+	s.Unlock()
+
+	if sessions == nil {
+		return pool
 	}
+	if p, ok := sessions.PoolFor(dst); ok {
+		return p
+	}
+	return pool
+}
 
-	fd, err := tun.Open(tunName)
-	if err != nil {
-		logrus.Fatal(err)
+// InjectReverseConn registers an already-established connection, typically
+// accepted by an agent-side reverse-forward listener and pushed back over
+// its own yamux stream, into the active session's connection pool (or the
+// pool set through SetConnPool when no session is active) as if raddr had
+// dialed in from outside. ServeReverseConns is the intended caller.
+func (s *NetStack) InjectReverseConn(conn net.Conn, raddr tcpip.FullAddress) error {
+	s.Lock()
+	pool, sessions := s.pool, s.sessions
+	s.Unlock()
+
+	if sessions != nil {
+		sessions.mu.Lock()
+		if p, ok := sessions.pools[sessions.active]; ok {
+			pool = p
+		}
+		sessions.mu.Unlock()
 	}
 
-	linkEP, err := fdbased.New(&fdbased.Options{FDs: []int{fd}, MTU: mtu})
+	if pool == nil || pool.Closed() {
+		return errors.New("connection pool is closed")
+	}
+
+	return pool.Add(TunConn{
+		Protocol: rawProtocolNumber,
+		Handler: RawConn{
+			Conn:       conn,
+			RemoteAddr: raddr,
+		},
+	})
+}
+
+// New creates a new userland network stack (using Gvisor) that listen on a tun interface.
+func (s *NetStack) new(tunName string) *stack.Stack {
+	linkEP, err := tun.Open(tunName)
 	if err != nil {
-		log.Fatal(err)
+		logrus.Fatal(err)
 	}
 
 	// Create a new gvisor userland network stack.
@@ -146,13 +265,13 @@ func (s *NetStack) new(tunName string) *stack.Stack {
 			EndpointID: request.ID(),
 			Request:    request,
 		}
-		s.Lock()
-		defer s.Unlock()
-		if s.pool == nil || s.pool.Closed() {
+
+		pool := s.poolFor(request.ID().LocalAddress)
+		if pool == nil || pool.Closed() {
 			return // If connPool is closed, ignore packet.
 		}
 
-		if err := s.pool.Add(TunConn{
+		if err := pool.Add(TunConn{
 			tcp.ProtocolNumber,
 			tcpConn,
 		}); err != nil {
@@ -168,14 +287,12 @@ func (s *NetStack) new(tunName string) *stack.Stack {
 			Request:    request,
 		}
 
-		s.Lock()
-		defer s.Unlock()
-
-		if s.pool == nil || s.pool.Closed() {
+		pool := s.poolFor(request.ID().LocalAddress)
+		if pool == nil || pool.Closed() {
 			return // If connPool is closed, ignore packet.
 		}
 
-		if err := s.pool.Add(TunConn{
+		if err := pool.Add(TunConn{
 			udp.ProtocolNumber,
 			udpConn,
 		}); err != nil {
@@ -0,0 +1,71 @@
+package socks
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"ligolo-ng/pkg/relay"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// handleConnect serves a plain HTTP CONNECT tunnel, dialing the requested
+// host:port through the NetStack and relaying bytes once the tunnel is
+// established.
+func (s *Server) handleConnect(conn net.Conn, r *bufio.Reader) {
+	defer conn.Close()
+
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		logrus.Errorf("proxy: failed to read HTTP request: %v", err)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		fmt.Fprintf(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+
+	host, portStr, err := net.SplitHostPort(req.Host)
+	if err != nil {
+		fmt.Fprintf(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		fmt.Fprintf(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		return
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// There is no DNS resolver on the far side of the tunnel: resolving
+		// here would query the proxy operator's own resolver, leaking the
+		// lookup outside the tunnel and still failing to find any name that
+		// only exists on the pivoted network. Require numeric targets
+		// instead of silently doing either of those things wrong.
+		logrus.Errorf("proxy: hostname targets are not supported, use a numeric address instead of %q", host)
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+
+	target, err := gonet.DialContextTCP(req.Context(), s.ns.GetStack(), tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFromSlice(ip),
+		Port: uint16(port),
+	}, ipv4ProtocolNumber(ip))
+	if err != nil {
+		logrus.Errorf("proxy: CONNECT to %s failed: %v", req.Host, err)
+		fmt.Fprintf(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+
+	if _, err := fmt.Fprintf(conn, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		target.Close()
+		return
+	}
+
+	relay.StartRelay(target, conn)
+}
@@ -0,0 +1,74 @@
+// Package socks exposes a local SOCKS5 (RFC 1928, including UDP ASSOCIATE)
+// and HTTP CONNECT listener whose dialed targets are reached through a
+// NetStack's gVisor stack. This lets operators point ordinary SOCKS/CONNECT
+// aware tools at the active agent's network without configuring any local
+// `ip route` entries. Targets must be numeric addresses: there is no
+// resolver on the far side of the tunnel, and resolving hostnames with the
+// proxy's own resolver would leak the lookup outside the tunnel.
+package socks
+
+import (
+	"bufio"
+	"github.com/sirupsen/logrus"
+	"ligolo-ng/pkg/proxy/netstack"
+	"net"
+)
+
+// Server accepts SOCKS5 or HTTP CONNECT connections on a single listener and
+// forwards them through a NetStack.
+type Server struct {
+	ns       *netstack.NetStack
+	listener net.Listener
+}
+
+// NewServer creates a Server bound to the given NetStack. Targets dialed by
+// clients are resolved against ns.GetStack(), i.e. through the currently
+// active agent.
+func NewServer(ns *netstack.NetStack) *Server {
+	return &Server{ns: ns}
+}
+
+// ListenAndServe binds addr and serves SOCKS5/HTTP CONNECT connections until
+// Close is called.
+func (s *Server) ListenAndServe(network, addr string) error {
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	s.listener = lis
+
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops the listener, disconnecting any future clients. In-flight
+// relayed connections are left to terminate on their own.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// handleConn peeks at the first byte to distinguish a SOCKS5 handshake
+// (version byte 0x05) from a plaintext HTTP CONNECT request line.
+func (s *Server) handleConn(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	version, err := r.Peek(1)
+	if err != nil {
+		logrus.Errorf("proxy: failed to read first byte: %v", err)
+		conn.Close()
+		return
+	}
+
+	if version[0] == socks5Version {
+		s.handleSocks5(conn, r)
+		return
+	}
+	s.handleConnect(conn, r)
+}
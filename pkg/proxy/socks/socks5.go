@@ -0,0 +1,333 @@
+package socks
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"io"
+	"ligolo-ng/pkg/relay"
+	"net"
+)
+
+const socks5Version = 0x05
+
+const (
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+)
+
+const (
+	atypIPv4   = 0x01
+	atypDomain = 0x03
+	atypIPv6   = 0x04
+)
+
+const (
+	repSucceeded           = 0x00
+	repHostUnreachable     = 0x04
+	repCommandNotSupported = 0x07
+)
+
+// handleSocks5 drives a RFC 1928 SOCKS5 connection: method negotiation,
+// request parsing, then either a CONNECT relay or a UDP ASSOCIATE session.
+func (s *Server) handleSocks5(conn net.Conn, r *bufio.Reader) {
+	defer conn.Close()
+
+	if err := negotiateMethod(r, conn); err != nil {
+		logrus.Errorf("socks5: method negotiation failed: %v", err)
+		return
+	}
+
+	cmd, addr, port, err := readSocks5Request(r)
+	if err != nil {
+		logrus.Errorf("socks5: failed to read request: %v", err)
+		return
+	}
+
+	switch cmd {
+	case socksCmdConnect:
+		s.handleSocks5Connect(conn, addr, port)
+	case socksCmdUDPAssociate:
+		s.handleSocks5UDPAssociate(conn, r)
+	default:
+		writeSocks5Reply(conn, repCommandNotSupported, nil, 0)
+	}
+}
+
+// negotiateMethod reads the client's greeting and always selects "no
+// authentication required", which is sufficient since the listener is
+// expected to be bound to 127.0.0.1 by the operator.
+func negotiateMethod(r *bufio.Reader, w io.Writer) error {
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(r, greeting); err != nil {
+		return err
+	}
+	if greeting[0] != socks5Version {
+		return fmt.Errorf("unsupported SOCKS version %d", greeting[0])
+	}
+
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return err
+	}
+
+	_, err := w.Write([]byte{socks5Version, 0x00})
+	return err
+}
+
+// readSocks5Request parses a SOCKS5 request (CMD, ATYP, DST.ADDR, DST.PORT).
+func readSocks5Request(r *bufio.Reader) (cmd byte, addr string, port uint16, err error) {
+	head := make([]byte, 4)
+	if _, err = io.ReadFull(r, head); err != nil {
+		return
+	}
+	cmd = head[1]
+
+	switch head[3] {
+	case atypIPv4:
+		raw := make([]byte, 4)
+		if _, err = io.ReadFull(r, raw); err != nil {
+			return
+		}
+		addr = net.IP(raw).String()
+	case atypIPv6:
+		raw := make([]byte, 16)
+		if _, err = io.ReadFull(r, raw); err != nil {
+			return
+		}
+		addr = net.IP(raw).String()
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(r, length); err != nil {
+			return
+		}
+		raw := make([]byte, length[0])
+		if _, err = io.ReadFull(r, raw); err != nil {
+			return
+		}
+		addr = string(raw)
+	default:
+		err = fmt.Errorf("unsupported address type %d", head[3])
+		return
+	}
+
+	portRaw := make([]byte, 2)
+	if _, err = io.ReadFull(r, portRaw); err != nil {
+		return
+	}
+	port = binary.BigEndian.Uint16(portRaw)
+	return
+}
+
+// writeSocks5Reply writes a SOCKS5 reply. bindAddr/bindPort are only
+// meaningful when rep is repSucceeded.
+func writeSocks5Reply(w io.Writer, rep byte, bindAddr net.IP, bindPort uint16) error {
+	if bindAddr == nil {
+		bindAddr = net.IPv4zero
+	}
+	v4 := bindAddr.To4()
+	atyp := byte(atypIPv4)
+	addrBytes := v4
+	if v4 == nil {
+		atyp = atypIPv6
+		addrBytes = bindAddr.To16()
+	}
+
+	reply := make([]byte, 0, 6+len(addrBytes))
+	reply = append(reply, socks5Version, rep, 0x00, atyp)
+	reply = append(reply, addrBytes...)
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, bindPort)
+	reply = append(reply, portBytes...)
+
+	_, err := w.Write(reply)
+	return err
+}
+
+// handleSocks5Connect dials addr:port through the NetStack and relays bytes
+// between the client and the resulting connection.
+func (s *Server) handleSocks5Connect(conn net.Conn, addr string, port uint16) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		// There is no DNS resolver on the far side of the tunnel: resolving
+		// here would query the proxy operator's own resolver, leaking the
+		// lookup outside the tunnel and still failing to find any name that
+		// only exists on the pivoted network. Require numeric targets
+		// instead of silently doing either of those things wrong.
+		logrus.Errorf("socks5: hostname targets are not supported, use a numeric address instead of %q", addr)
+		writeSocks5Reply(conn, repHostUnreachable, nil, 0)
+		return
+	}
+
+	target, err := s.dialTCP(ip, port)
+	if err != nil {
+		logrus.Errorf("socks5: connect to %s:%d failed: %v", addr, port, err)
+		writeSocks5Reply(conn, repHostUnreachable, nil, 0)
+		return
+	}
+
+	if err := writeSocks5Reply(conn, repSucceeded, net.IPv4zero, 0); err != nil {
+		target.Close()
+		return
+	}
+
+	relay.StartRelay(target, conn)
+}
+
+// handleSocks5UDPAssociate binds a local UDP socket used to relay datagrams
+// from the client to arbitrary destinations on the agent's network.
+func (s *Server) handleSocks5UDPAssociate(conn net.Conn, r *bufio.Reader) {
+	udpLis, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		writeSocks5Reply(conn, repHostUnreachable, nil, 0)
+		return
+	}
+	defer udpLis.Close()
+
+	bound := udpLis.LocalAddr().(*net.UDPAddr)
+	if err := writeSocks5Reply(conn, repSucceeded, net.IPv4zero, uint16(bound.Port)); err != nil {
+		return
+	}
+
+	go s.relayUDPAssociate(udpLis)
+
+	// The TCP control connection stays open for the lifetime of the
+	// association; its closure tells us the client is done.
+	buf := make([]byte, 1)
+	_, _ = r.Read(buf)
+}
+
+// relayUDPAssociate reads SOCKS5 UDP request headers off udpLis, dials the
+// embedded destination through the NetStack, and relays the single
+// datagram's response back to the client.
+func (s *Server) relayUDPAssociate(udpLis *net.UDPConn) {
+	buf := make([]byte, 0xffff)
+	for {
+		n, clientAddr, err := udpLis.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		// parseUDPRequest returns addr/payload as sub-slices of its input;
+		// copy the datagram out of the reused buf before handing those off
+		// to forwardUDPDatagram as a goroutine, since the next loop
+		// iteration will overwrite buf as soon as it runs.
+		datagram := append([]byte(nil), buf[:n]...)
+		addr, port, payload, err := parseUDPRequest(datagram)
+		if err != nil {
+			logrus.Errorf("socks5: invalid UDP request: %v", err)
+			continue
+		}
+
+		go s.forwardUDPDatagram(udpLis, clientAddr, addr, port, payload)
+	}
+}
+
+// forwardUDPDatagram sends payload to addr:port through the NetStack and
+// relays at most one response datagram back to clientAddr.
+func (s *Server) forwardUDPDatagram(udpLis *net.UDPConn, clientAddr *net.UDPAddr, addr net.IP, port uint16, payload []byte) {
+	remoteConn, err := s.dialUDP(addr, port)
+	if err != nil {
+		logrus.Errorf("socks5: UDP dial %s:%d failed: %v", addr, port, err)
+		return
+	}
+	defer remoteConn.Close()
+
+	if _, err := remoteConn.Write(payload); err != nil {
+		return
+	}
+
+	resp := make([]byte, 0xffff)
+	n, err := remoteConn.Read(resp)
+	if err != nil {
+		return
+	}
+
+	header := buildUDPHeader(addr, port)
+	_, _ = udpLis.WriteToUDP(append(header, resp[:n]...), clientAddr)
+}
+
+// parseUDPRequest decodes a SOCKS5 UDP request datagram (RSV, RSV, FRAG,
+// ATYP, DST.ADDR, DST.PORT, DATA). Fragmentation is not supported.
+func parseUDPRequest(b []byte) (addr net.IP, port uint16, payload []byte, err error) {
+	if len(b) < 4 {
+		return nil, 0, nil, fmt.Errorf("short UDP request")
+	}
+	if b[2] != 0 {
+		return nil, 0, nil, fmt.Errorf("fragmented UDP requests are not supported")
+	}
+
+	i := 4
+	switch b[3] {
+	case atypIPv4:
+		if len(b) < i+4+2 {
+			return nil, 0, nil, fmt.Errorf("short IPv4 UDP request")
+		}
+		addr = net.IP(b[i : i+4])
+		i += 4
+	case atypIPv6:
+		if len(b) < i+16+2 {
+			return nil, 0, nil, fmt.Errorf("short IPv6 UDP request")
+		}
+		addr = net.IP(b[i : i+16])
+		i += 16
+	default:
+		return nil, 0, nil, fmt.Errorf("unsupported UDP address type %d", b[3])
+	}
+
+	port = binary.BigEndian.Uint16(b[i : i+2])
+	i += 2
+	payload = b[i:]
+	return
+}
+
+// buildUDPHeader constructs the SOCKS5 UDP response header for addr:port.
+func buildUDPHeader(addr net.IP, port uint16) []byte {
+	v4 := addr.To4()
+	atyp := byte(atypIPv4)
+	addrBytes := v4
+	if v4 == nil {
+		atyp = atypIPv6
+		addrBytes = addr.To16()
+	}
+
+	out := make([]byte, 0, 4+len(addrBytes)+2)
+	out = append(out, 0, 0, 0, atyp)
+	out = append(out, addrBytes...)
+	portBytes := make([]byte, 2)
+	binary.BigEndian.PutUint16(portBytes, port)
+	return append(out, portBytes...)
+}
+
+// dialTCP dials addr:port through the active agent's NetStack.
+func (s *Server) dialTCP(addr net.IP, port uint16) (net.Conn, error) {
+	netProto := ipv4ProtocolNumber(addr)
+	return gonet.DialContextTCP(context.Background(), s.ns.GetStack(), tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFromSlice(addr),
+		Port: port,
+	}, netProto)
+}
+
+// dialUDP dials addr:port through the active agent's NetStack.
+func (s *Server) dialUDP(addr net.IP, port uint16) (net.Conn, error) {
+	netProto := ipv4ProtocolNumber(addr)
+	return gonet.DialUDP(s.ns.GetStack(), nil, &tcpip.FullAddress{
+		NIC:  1,
+		Addr: tcpip.AddrFromSlice(addr),
+		Port: port,
+	}, netProto)
+}
+
+// ipv4ProtocolNumber returns the gVisor network protocol number matching addr.
+func ipv4ProtocolNumber(addr net.IP) tcpip.NetworkProtocolNumber {
+	if addr.To4() != nil {
+		return header.IPv4ProtocolNumber
+	}
+	return header.IPv6ProtocolNumber
+}
@@ -0,0 +1,119 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func init() {
+	Register("h2", newHTTP2Carrier)
+}
+
+// http2Carrier tunnels the yamux session through an HTTP/2 CONNECT request,
+// which is commonly allowed out through corporate TLS-inspecting proxies
+// that would otherwise block a bare TLS handshake.
+type http2Carrier struct {
+	target    *url.URL
+	tlsConfig *tls.Config
+}
+
+func newHTTP2Carrier(target *url.URL, tlsConfig *tls.Config) (Carrier, error) {
+	return &http2Carrier{target: target, tlsConfig: tlsConfig}, nil
+}
+
+// Dial implements Carrier.
+func (c *http2Carrier) Dial(ctx context.Context) (net.Conn, error) {
+	proxyAddr := c.target.Host
+	if proxyAddr == "" {
+		return nil, fmt.Errorf("h2 transport requires a proxy host")
+	}
+
+	rawConn, err := (&tls.Dialer{Config: c.tlsConfig}).DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http2.Transport{}
+	clientConn, err := transport.NewClientConn(rawConn)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+
+	target := c.target.Query().Get("target")
+	if target == "" {
+		return nil, fmt.Errorf("h2 transport requires ?target=host:port")
+	}
+
+	pr, pw := io.Pipe()
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Body:   pr,
+	}
+	if user := c.target.Query().Get("proxy-user"); user != "" {
+		req.Header = http.Header{
+			"Proxy-Authorization": {"Basic " + basicAuth(user, c.target.Query().Get("proxy-pass"))},
+		}
+	}
+
+	resp, err := clientConn.RoundTrip(req)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		rawConn.Close()
+		return nil, fmt.Errorf("h2 CONNECT to %s failed: %s", target, resp.Status)
+	}
+
+	return &http2Conn{
+		reader:     resp.Body,
+		writer:     pw,
+		rawConn:    rawConn,
+		clientConn: clientConn,
+		local:      rawConn.LocalAddr(),
+		remote:     rawConn.RemoteAddr(),
+	}, nil
+}
+
+// http2Conn adapts the half-duplex request body / response body pair of an
+// HTTP/2 CONNECT tunnel into a net.Conn. It also owns the underlying TCP
+// socket (rawConn) and the http2.ClientConn built on top of it, so closing
+// it doesn't leak either.
+type http2Conn struct {
+	reader     io.ReadCloser
+	writer     io.WriteCloser
+	rawConn    net.Conn
+	clientConn *http2.ClientConn
+	local      net.Addr
+	remote     net.Addr
+}
+
+func (c *http2Conn) Read(b []byte) (int, error)  { return c.reader.Read(b) }
+func (c *http2Conn) Write(b []byte) (int, error) { return c.writer.Write(b) }
+func (c *http2Conn) Close() error {
+	c.writer.Close()
+	c.reader.Close()
+	c.clientConn.Close()
+	return c.rawConn.Close()
+}
+func (c *http2Conn) LocalAddr() net.Addr                { return c.local }
+func (c *http2Conn) RemoteAddr() net.Addr               { return c.remote }
+func (c *http2Conn) SetDeadline(t time.Time) error      { return nil }
+func (c *http2Conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *http2Conn) SetWriteDeadline(t time.Time) error { return nil }
+
+func basicAuth(user, pass string) string {
+	return base64.StdEncoding.EncodeToString([]byte(user + ":" + pass))
+}
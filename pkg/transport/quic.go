@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func init() {
+	Register("quic", newQUICCarrier)
+}
+
+// quicCarrier tunnels the yamux session over a single QUIC stream. QUIC rides
+// on UDP/443, which is rarely inspected as closely as TCP/443 and survives
+// some classes of TCP-based blocking.
+type quicCarrier struct {
+	addr      string
+	tlsConfig *tls.Config
+}
+
+func newQUICCarrier(target *url.URL, tlsConfig *tls.Config) (Carrier, error) {
+	cfg := tlsConfig.Clone()
+	if len(cfg.NextProtos) == 0 {
+		cfg.NextProtos = []string{"ligolo-ng"}
+	}
+	return &quicCarrier{addr: target.Host, tlsConfig: cfg}, nil
+}
+
+// Dial implements Carrier.
+func (c *quicCarrier) Dial(ctx context.Context) (net.Conn, error) {
+	conn, err := quic.DialAddr(ctx, c.addr, c.tlsConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "stream open failed")
+		return nil, err
+	}
+
+	return &quicConn{stream: stream, conn: conn}, nil
+}
+
+// quicConn adapts a quic.Stream (plus its parent quic.Connection, for
+// addressing) into a net.Conn.
+type quicConn struct {
+	stream quic.Stream
+	conn   quic.Connection
+}
+
+func (c *quicConn) Read(b []byte) (int, error)  { return c.stream.Read(b) }
+func (c *quicConn) Write(b []byte) (int, error) { return c.stream.Write(b) }
+func (c *quicConn) Close() error {
+	c.stream.Close()
+	return c.conn.CloseWithError(0, "")
+}
+func (c *quicConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+func (c *quicConn) SetDeadline(t time.Time) error {
+	return c.stream.SetDeadline(t)
+}
+func (c *quicConn) SetReadDeadline(t time.Time) error  { return c.stream.SetReadDeadline(t) }
+func (c *quicConn) SetWriteDeadline(t time.Time) error { return c.stream.SetWriteDeadline(t) }
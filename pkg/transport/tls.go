@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+)
+
+func init() {
+	Register("tls", newTLSCarrier)
+}
+
+// tlsCarrier dials the proxy over raw TLS-over-TCP. This is the original
+// ligolo-ng transport and remains the default.
+type tlsCarrier struct {
+	addr      string
+	tlsConfig *tls.Config
+}
+
+func newTLSCarrier(target *url.URL, tlsConfig *tls.Config) (Carrier, error) {
+	return &tlsCarrier{addr: target.Host, tlsConfig: tlsConfig}, nil
+}
+
+// Dial implements Carrier.
+func (c *tlsCarrier) Dial(ctx context.Context) (net.Conn, error) {
+	var dialer tls.Dialer
+	dialer.Config = c.tlsConfig
+	return dialer.DialContext(ctx, "tcp", c.addr)
+}
@@ -0,0 +1,71 @@
+// Package transport provides pluggable carriers for the yamux session
+// established between the agent and the proxy. Historically ligolo-ng only
+// supported raw TLS over TCP; this package lets that be swapped for
+// WebSocket, HTTP/2 CONNECT or QUIC carriers to better survive egress
+// filtering, while keeping the yamux layer above it unchanged.
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// Carrier dials the proxy and returns a net.Conn ready to be wrapped in a
+// yamux session.
+type Carrier interface {
+	Dial(ctx context.Context) (net.Conn, error)
+}
+
+// Factory builds a Carrier from a parsed -connect URL and the agent's TLS
+// configuration.
+type Factory func(target *url.URL, tlsConfig *tls.Config) (Carrier, error)
+
+var registry = map[string]Factory{}
+
+// Register associates a URL scheme (e.g. "tls", "wss", "h2", "quic") with a
+// Carrier Factory. Carrier implementations call this from an init() function.
+func Register(scheme string, factory Factory) {
+	registry[scheme] = factory
+}
+
+// Dial parses rawURL, selects the matching registered Carrier and dials it.
+// A bare "host:port" address (no scheme) defaults to the "tls" carrier for
+// backward compatibility with pre-transport ligolo-ng agents.
+func Dial(ctx context.Context, rawURL string, tlsConfig *tls.Config) (net.Conn, error) {
+	target, err := parseTarget(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	factory, ok := registry[target.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported transport %q", target.Scheme)
+	}
+
+	carrier, err := factory(target, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("build %s carrier: %w", target.Scheme, err)
+	}
+
+	return carrier.Dial(ctx)
+}
+
+// parseTarget turns a -connect value into a URL, defaulting to the "tls"
+// scheme when none is given so that plain "host:port" addresses keep working.
+func parseTarget(rawURL string) (*url.URL, error) {
+	if _, _, err := net.SplitHostPort(rawURL); err == nil {
+		return &url.URL{Scheme: "tls", Host: rawURL}, nil
+	}
+
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -connect value %q: %w", rawURL, err)
+	}
+	if target.Scheme == "" {
+		return nil, fmt.Errorf("invalid -connect value %q: missing scheme or port", rawURL)
+	}
+	return target, nil
+}
@@ -0,0 +1,44 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+
+	"nhooyr.io/websocket"
+)
+
+func init() {
+	Register("ws", newWebsocketCarrier)
+	Register("wss", newWebsocketCarrier)
+}
+
+// websocketCarrier tunnels the yamux session inside a WebSocket connection,
+// which passes through most reverse proxies and egress filters that allow
+// regular browser traffic.
+type websocketCarrier struct {
+	target    *url.URL
+	tlsConfig *tls.Config
+}
+
+func newWebsocketCarrier(target *url.URL, tlsConfig *tls.Config) (Carrier, error) {
+	return &websocketCarrier{target: target, tlsConfig: tlsConfig}, nil
+}
+
+// Dial implements Carrier.
+func (c *websocketCarrier) Dial(ctx context.Context) (net.Conn, error) {
+	httpClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: c.tlsConfig},
+	}
+
+	conn, _, err := websocket.Dial(ctx, c.target.String(), &websocket.DialOptions{
+		HTTPClient: httpClient,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return websocket.NetConn(ctx, conn, websocket.MessageBinary), nil
+}
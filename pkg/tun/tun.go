@@ -0,0 +1,13 @@
+// Package tun provides a platform-abstracted way of obtaining a gVisor
+// stack.LinkEndpoint bound to the host's TUN interface. Each supported
+// platform implements Open in its own build-tagged file.
+package tun
+
+import "gvisor.dev/gvisor/pkg/tcpip/stack"
+
+// Open creates (or attaches to) the TUN interface named tunName and returns
+// a gVisor LinkEndpoint that can be registered as a NIC. The implementation
+// is selected at build time depending on the target platform.
+func Open(tunName string) (stack.LinkEndpoint, error) {
+	return open(tunName)
+}
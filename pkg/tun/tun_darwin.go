@@ -0,0 +1,56 @@
+//go:build darwin
+
+package tun
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// utunControlName is the kernel control name used to create utun interfaces.
+const utunControlName = "com.apple.net.utun_control"
+
+const defaultMTU = 1500
+
+// open creates (or attaches to) a macOS utun interface using the
+// PF_SYSTEM/SYSPROTO_CONTROL kernel control socket and wraps the resulting
+// fd in a fdbased gVisor LinkEndpoint.
+func open(tunName string) (stack.LinkEndpoint, error) {
+	fd, err := unix.Socket(unix.AF_SYSTEM, unix.SOCK_DGRAM, unix.SYSPROTO_CONTROL)
+	if err != nil {
+		return nil, fmt.Errorf("open utun control socket: %w", err)
+	}
+
+	ctlInfo := &unix.CtlInfo{}
+	copy(ctlInfo.Name[:], utunControlName)
+	if err := unix.IoctlCtlInfo(fd, ctlInfo); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("utun ctl info: %w", err)
+	}
+
+	unitNum, err := utunUnitNumber(tunName)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	sc := &unix.SockaddrCtl{ID: ctlInfo.Id, Unit: unitNum}
+	if err := unix.Connect(fd, sc); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("connect utun%d: %w", unitNum-1, err)
+	}
+
+	return fdbased.New(&fdbased.Options{FDs: []int{fd}, MTU: defaultMTU})
+}
+
+// utunUnitNumber parses a "utunN" interface name into the unit number
+// expected by SockaddrCtl (N+1, 0 means "pick the next free unit").
+func utunUnitNumber(tunName string) (uint32, error) {
+	var n uint32
+	if _, err := fmt.Sscanf(tunName, "utun%d", &n); err != nil {
+		return 0, fmt.Errorf("invalid utun interface name %q: %w", tunName, err)
+	}
+	return n + 1, nil
+}
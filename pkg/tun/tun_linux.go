@@ -0,0 +1,26 @@
+//go:build linux
+
+package tun
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/link/rawfile"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// open opens the named Linux TUN device and wraps its file descriptor in a
+// fdbased gVisor LinkEndpoint.
+func open(tunName string) (stack.LinkEndpoint, error) {
+	mtu, err := rawfile.GetMTU(tunName)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := tun.Open(tunName)
+	if err != nil {
+		return nil, err
+	}
+
+	return fdbased.New(&fdbased.Options{FDs: []int{fd}, MTU: mtu})
+}
@@ -0,0 +1,80 @@
+//go:build windows
+
+package tun
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+const defaultMTU = 1500
+
+// packetQueueLen is the depth of the channel.Endpoint used to bridge the
+// Wintun ring buffers into the gVisor stack.
+const packetQueueLen = 512
+
+// open creates (or opens) the named Wintun adapter and bridges its
+// session-based ring buffers into a gVisor channel.Endpoint, since Wintun
+// is not backed by a pollable file descriptor like its Unix counterparts.
+func open(tunName string) (stack.LinkEndpoint, error) {
+	device, err := tun.CreateTUN(tunName, defaultMTU)
+	if err != nil {
+		return nil, fmt.Errorf("create wintun adapter %q: %w", tunName, err)
+	}
+
+	mtu, err := device.MTU()
+	if err != nil {
+		mtu = defaultMTU
+	}
+
+	ep := channel.New(packetQueueLen, uint32(mtu), "")
+	go pumpReads(device, ep, mtu)
+	go pumpWrites(device, ep)
+
+	return ep, nil
+}
+
+// pumpReads copies packets from the Wintun ring buffer into the channel.Endpoint.
+func pumpReads(device tun.Device, ep *channel.Endpoint, mtu int) {
+	bufs := make([][]byte, 1)
+	bufs[0] = make([]byte, mtu+16)
+	sizes := make([]int, 1)
+
+	for {
+		n, err := device.Read(bufs, sizes, 16)
+		if err != nil {
+			logrus.Errorf("wintun read error: %v", err)
+			return
+		}
+		for i := 0; i < n; i++ {
+			pkt := append([]byte(nil), bufs[i][16:16+sizes[i]]...)
+			proto := header.IPv4ProtocolNumber
+			if len(pkt) > 0 && pkt[0]>>4 == 6 {
+				proto = header.IPv6ProtocolNumber
+			}
+			ep.InjectInbound(proto, stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(pkt),
+			}))
+		}
+	}
+}
+
+// pumpWrites copies packets queued by the gVisor stack back out through the Wintun session.
+func pumpWrites(device tun.Device, ep *channel.Endpoint) {
+	for {
+		pkt := ep.ReadContext(nil)
+		if pkt == nil {
+			return
+		}
+		buf := pkt.ToBuffer()
+		data := buf.Flatten()
+		if _, err := device.Write([][]byte{data}, 0); err != nil {
+			logrus.Errorf("wintun write error: %v", err)
+		}
+	}
+}